@@ -2,18 +2,25 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -28,6 +35,10 @@ var invalidArg = errors.New("invalid argument")
 var (
 	cred       = flag.String("cred", os.Getenv("DAVC_CRED"), "credential for basic auth (user:password)")
 	prompthere = flag.Bool("prompthere", false, "display location at prompt")
+	resume     = flag.Bool("resume", false, "resume interrupted put/get transfers")
+	chunksize  = flag.Int64("chunksize", 4<<20, "chunk size in bytes for put/get transfers")
+	scriptFile = flag.String("f", "", "read commands from a script file instead of the REPL")
+	onError    = flag.String("on-error", "stop", "stop|continue when a scripted command fails")
 )
 
 func fatalRequiredAuth(err error) {
@@ -49,6 +60,144 @@ func escape(s string) string {
 	return esc.Replace(s)
 }
 
+// davcpart is the sidecar state recorded next to a partially completed
+// transfer so it can be resumed with -resume.
+type davcpart struct {
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	Offset  int64     `json:"offset"`
+}
+
+func partFile(local string) string {
+	return local + ".davcpart"
+}
+
+func loadPart(local, remote string) (*davcpart, error) {
+	b, err := ioutil.ReadFile(partFile(local))
+	if err != nil {
+		return nil, err
+	}
+	var p davcpart
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.URL != remote {
+		return nil, invalidArg
+	}
+	return &p, nil
+}
+
+func savePart(local string, p *davcpart) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(partFile(local), b, 0644)
+}
+
+func removePart(local string) {
+	os.Remove(partFile(local))
+}
+
+func humanBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	div, exp := float64(unit), 0
+	for x := n / unit; x >= unit; x /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", n/div, "KMGTPE"[exp])
+}
+
+// progress renders a live bytes/sec, ETA and percent indicator to
+// color.Output while count bytes flow through Read or Write.
+type progress struct {
+	label    string
+	total    int64
+	done     int64
+	start    time.Time
+	lastDraw time.Time
+}
+
+func newProgress(label string, total int64) *progress {
+	return &progress{label: label, total: total, start: time.Now()}
+}
+
+func (p *progress) add(n int) {
+	p.done += int64(n)
+	if time.Since(p.lastDraw) < 100*time.Millisecond {
+		return
+	}
+	p.draw()
+}
+
+func (p *progress) draw() {
+	p.lastDraw = time.Now()
+	elapsed := time.Since(p.start).Seconds()
+	rate := float64(0)
+	if elapsed > 0 {
+		rate = float64(p.done) / elapsed
+	}
+	var pct string
+	var eta string
+	if p.total > 0 {
+		pct = fmt.Sprintf("%5.1f%%", float64(p.done)/float64(p.total)*100)
+		if rate > 0 {
+			eta = time.Duration(float64(p.total-p.done) / rate * float64(time.Second)).Truncate(time.Second).String()
+		} else {
+			eta = "?"
+		}
+	} else {
+		pct = "  ?  "
+		eta = "?"
+	}
+	fmt.Fprint(color.Output, "\r"+color.CyanString(p.label)+" "+pct+"  "+humanBytes(rate)+"/s  ETA "+eta+"   ")
+}
+
+func (p *progress) done_() {
+	p.draw()
+	fmt.Fprintln(color.Output)
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(n)
+	return n, err
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(n)
+	return n, err
+}
+
+// copyChunked copies src to dst chunksize bytes at a time, reporting
+// progress as it goes. It behaves like io.Copy otherwise.
+func copyChunked(dst io.Writer, src io.Reader, size int64, p *progress) (int64, error) {
+	buf := make([]byte, size)
+	return io.CopyBuffer(&progressWriter{w: dst, p: p}, src, buf)
+}
+
+// chunkedBody wraps r so the HTTP client reads it in chunksize-sized
+// pieces, the put-side equivalent of copyChunked's buffer for get.
+func chunkedBody(r io.Reader, size int64) io.Reader {
+	return bufio.NewReaderSize(r, int(size))
+}
+
 func parseArgs(args []string) (opts map[string]bool, retargs []string) {
 	opts = map[string]bool{}
 	for _, arg := range args {
@@ -61,7 +210,343 @@ func parseArgs(args []string) (opts map[string]bool, retargs []string) {
 	return
 }
 
-func handle(client *gowebdav.Client, cwd *string, args []string) error {
+// parseKVArgs splits args into --key=value options, bare -flag/--flag
+// booleans, and positional arguments, for commands that need more than
+// the boolean-only opts parseArgs supports.
+func parseKVArgs(args []string) (opts map[string]string, flags map[string]bool, retargs []string) {
+	opts = map[string]string{}
+	flags = map[string]bool{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			kv := arg[2:]
+			if i := strings.IndexByte(kv, '='); i >= 0 {
+				opts[kv[:i]] = kv[i+1:]
+			} else {
+				flags[kv] = true
+			}
+		case strings.HasPrefix(arg, "-"):
+			flags[arg[1:]] = true
+		default:
+			retargs = append(retargs, arg)
+		}
+	}
+	return
+}
+
+func matchFilters(rel, include, exclude string) bool {
+	if exclude != "" {
+		if ok, _ := path.Match(exclude, rel); ok {
+			return false
+		}
+	}
+	if include != "" {
+		if ok, _ := path.Match(include, rel); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// syncOp describes one planned or executed transfer/delete, printable
+// as JSON with -json in the same style as ls.
+type syncOp struct {
+	Action string `json:"action"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	Size   int64  `json:"size"`
+}
+
+func printSyncOps(ops []syncOp, jsonout bool) {
+	if jsonout {
+		json.NewEncoder(color.Output).Encode(ops)
+		return
+	}
+	for _, op := range ops {
+		switch op.Action {
+		case "delete":
+			fmt.Fprintln(color.Output, color.RedString("delete")+" "+op.Remote)
+		case "skip":
+			fmt.Fprintln(color.Output, "skip   "+op.Remote)
+		default:
+			fmt.Fprintln(color.Output, color.GreenString(op.Action)+"   "+op.Remote)
+		}
+	}
+}
+
+// walkRemote recursively lists every regular file under root.
+func walkRemote(client *gowebdav.Client, root string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	var walk func(string) error
+	walk = func(dir string) error {
+		fis, err := client.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			p := path.Join(dir, fi.Name())
+			if fi.IsDir() {
+				if err := walk(p + "/"); err != nil {
+					return err
+				}
+				continue
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+			files[rel] = fi
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// walkLocal is walkRemote for the local filesystem, using slash
+// separators for the relative keys so they compare directly with
+// walkRemote's.
+func walkLocal(root string) (map[string]os.FileInfo, error) {
+	files := map[string]os.FileInfo{}
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func runParallel(n int, jobs []func() error) error {
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job func() error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := job(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(job)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// doMget recursively mirrors a remote directory tree to the local disk.
+func doMget(client *gowebdav.Client, cwd *string, lwd string, args []string) error {
+	opts, flags, rest := parseKVArgs(args)
+	if len(rest) < 1 || len(rest) > 2 {
+		return invalidArg
+	}
+	remote := rest[0]
+	if !path.IsAbs(remote) {
+		remote = path.Join(*cwd, remote)
+	}
+	if !strings.HasSuffix(remote, "/") {
+		remote += "/"
+	}
+	local := lwd
+	if len(rest) == 2 {
+		local = rest[1]
+		if !filepath.IsAbs(local) {
+			local = filepath.Join(lwd, local)
+		}
+	}
+	parallel, _ := strconv.Atoi(opts["parallel"])
+	dryRun, del, jsonout := flags["dry-run"], flags["delete"], flags["json"]
+
+	rfiles, err := walkRemote(client, remote)
+	if err != nil {
+		return err
+	}
+	lfiles, err := walkLocal(local)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	ops := []syncOp{}
+	var jobs []func() error
+	for rel, fi := range rfiles {
+		if !matchFilters(rel, opts["include"], opts["exclude"]) {
+			continue
+		}
+		lp := filepath.Join(local, filepath.FromSlash(rel))
+		rp := path.Join(remote, rel)
+		op := syncOp{Action: "transfer", Local: lp, Remote: rp, Size: fi.Size()}
+		if lfi, ok := lfiles[rel]; ok && lfi.Size() == fi.Size() && lfi.ModTime().Equal(fi.ModTime()) {
+			op.Action = "skip"
+		}
+		ops = append(ops, op)
+		if op.Action == "skip" || dryRun {
+			continue
+		}
+		lp2, rp2, mtime := lp, rp, fi.ModTime()
+		jobs = append(jobs, func() error {
+			if err := os.MkdirAll(filepath.Dir(lp2), 0755); err != nil {
+				return err
+			}
+			strm, err := client.ReadStream(rp2)
+			if err != nil {
+				return err
+			}
+			defer strm.Close()
+			f, err := os.Create(lp2)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(f, strm)
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// Match the remote's recorded mtime so the next sync's
+			// size+mtime comparison sees this file as unchanged.
+			return os.Chtimes(lp2, mtime, mtime)
+		})
+	}
+	if del && !dryRun {
+		for rel, lfi := range lfiles {
+			if _, ok := rfiles[rel]; !ok {
+				lp := filepath.Join(local, filepath.FromSlash(rel))
+				ops = append(ops, syncOp{Action: "delete", Local: lp, Remote: path.Join(remote, rel), Size: lfi.Size()})
+				jobs = append(jobs, func(lp string) func() error {
+					return func() error { return os.Remove(lp) }
+				}(lp))
+			}
+		}
+	}
+	printSyncOps(ops, jsonout)
+	if dryRun {
+		return nil
+	}
+	return runParallel(parallel, jobs)
+}
+
+// doMput recursively mirrors a local directory tree to the remote.
+func doMput(client *gowebdav.Client, cwd *string, lwd string, args []string) error {
+	opts, flags, rest := parseKVArgs(args)
+	if len(rest) < 1 || len(rest) > 2 {
+		return invalidArg
+	}
+	local := rest[0]
+	if !filepath.IsAbs(local) {
+		local = filepath.Join(lwd, local)
+	}
+	remote := *cwd
+	if len(rest) == 2 {
+		remote = rest[1]
+		if !path.IsAbs(remote) {
+			remote = path.Join(*cwd, remote)
+		}
+	}
+	if !strings.HasSuffix(remote, "/") {
+		remote += "/"
+	}
+	parallel, _ := strconv.Atoi(opts["parallel"])
+	dryRun, del, jsonout := flags["dry-run"], flags["delete"], flags["json"]
+
+	lfiles, err := walkLocal(local)
+	if err != nil {
+		return err
+	}
+	rfiles, err := walkRemote(client, remote)
+	if err != nil {
+		if client.MkdirAll(remote, 0755) != nil {
+			return err
+		}
+		rfiles = map[string]os.FileInfo{}
+	}
+
+	ops := []syncOp{}
+	var jobs []func() error
+	for rel, fi := range lfiles {
+		if !matchFilters(rel, opts["include"], opts["exclude"]) {
+			continue
+		}
+		lp := filepath.Join(local, filepath.FromSlash(rel))
+		rp := path.Join(remote, rel)
+		op := syncOp{Action: "transfer", Local: lp, Remote: rp, Size: fi.Size()}
+		if rfi, ok := rfiles[rel]; ok && rfi.Size() == fi.Size() && rfi.ModTime().Equal(fi.ModTime()) {
+			op.Action = "skip"
+		}
+		ops = append(ops, op)
+		if op.Action == "skip" || dryRun {
+			continue
+		}
+		lp2, rp2 := lp, rp
+		jobs = append(jobs, func() error {
+			if err := client.MkdirAll(path.Dir(rp2), 0755); err != nil {
+				return err
+			}
+			f, err := os.Open(lp2)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := client.WriteStream(rp2, f, 0644); err != nil {
+				return err
+			}
+			// Match the local mtime to whatever the server now reports,
+			// so the next sync's size+mtime comparison sees this file
+			// as unchanged instead of re-transferring it every run.
+			rfi, err := client.Stat(rp2)
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(lp2, rfi.ModTime(), rfi.ModTime())
+		})
+	}
+	if del && !dryRun {
+		for rel, rfi := range rfiles {
+			if _, ok := lfiles[rel]; !ok {
+				rp := path.Join(remote, rel)
+				ops = append(ops, syncOp{Action: "delete", Local: filepath.Join(local, filepath.FromSlash(rel)), Remote: rp, Size: rfi.Size()})
+				jobs = append(jobs, func(rp string) func() error {
+					return func() error { return client.Remove(rp) }
+				}(rp))
+			}
+		}
+	}
+	printSyncOps(ops, jsonout)
+	if dryRun {
+		return nil
+	}
+	return runParallel(parallel, jobs)
+}
+
+// doSync is mput with change detection reused for one-way local-to-remote
+// synchronization; --delete removes remote files no longer present locally.
+func doSync(client *gowebdav.Client, cwd *string, lwd string, args []string) error {
+	return doMput(client, cwd, lwd, args)
+}
+
+func handle(sess *Session, cwd *string, args []string) error {
+	client := sess.Client
 	lwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -252,7 +737,7 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 		if !path.IsAbs(p) {
 			p = path.Join(*cwd, p)
 		}
-		err := client.Remove(p)
+		err := sess.rawDelete(p, sess.lockHeaders(p))
 		if err != nil {
 			return err
 		}
@@ -288,16 +773,60 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 		if !filepath.IsAbs(p) {
 			p = filepath.Join(lwd, p)
 		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
 		f, err := os.Open(p)
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 		_, file := filepath.Split(p)
 		file = path.Join(*cwd, file)
-		err = client.WriteStream(file, f, 0644)
+
+		var offset int64
+		if *resume {
+			if part, perr := loadPart(p, file); perr == nil && part.Size == fi.Size() && part.ModTime.Equal(fi.ModTime()) {
+				if rfi, serr := client.Stat(file); serr == nil && rfi.Size() == part.Offset {
+					offset = part.Offset
+				}
+			}
+		}
+		headers := sess.lockHeaders(file)
+		if offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", offset, fi.Size()-1, fi.Size())
+		}
+
+		pr := newProgress(filepath.Base(p), fi.Size())
+		pr.done = offset
+		err = sess.rawPut(file, chunkedBody(&progressReader{r: f, p: pr}, *chunksize), headers)
+		if err != nil && offset > 0 {
+			// The server rejected (or ignored and choked on) the
+			// partial PUT; fall back to a full re-upload from the
+			// start instead of giving up on the transfer outright.
+			if _, serr := f.Seek(0, io.SeekStart); serr == nil {
+				offset = 0
+				pr = newProgress(filepath.Base(p), fi.Size())
+				err = sess.rawPut(file, chunkedBody(&progressReader{r: f, p: pr}, *chunksize), sess.lockHeaders(file))
+			}
+		}
 		if err != nil {
+			if *resume {
+				savePart(p, &davcpart{URL: file, Size: fi.Size(), ModTime: fi.ModTime(), Offset: pr.done})
+			}
 			return err
 		}
+		pr.done_()
+		if *resume {
+			removePart(p)
+		}
 	case "get":
 		if len(args) != 2 {
 			return invalidArg
@@ -307,21 +836,71 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 			p = path.Join(*cwd, p)
 		}
 		_, file := path.Split(p)
-		strm, err := client.ReadStream(p)
+		rfi, err := client.Stat(p)
 		if err != nil {
 			return err
 		}
-		defer strm.Close()
-		f, err := os.Create(file)
+
+		var offset int64
+		flags := os.O_WRONLY | os.O_CREATE
+		if *resume {
+			if part, perr := loadPart(file, p); perr == nil && part.Size == rfi.Size() && part.ModTime.Equal(rfi.ModTime()) {
+				if lfi, serr := os.Stat(file); serr == nil && lfi.Size() == part.Offset {
+					offset = part.Offset
+					flags |= os.O_APPEND
+				}
+			}
+		}
+		if flags&os.O_APPEND == 0 {
+			flags |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(file, flags, 0644)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, err = io.Copy(f, strm)
+
+		var headers map[string]string
+		if offset > 0 {
+			headers = map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}
+		}
+		strm, partial, err := sess.rawGet(p, headers)
+		if err != nil {
+			return err
+		}
+		defer strm.Close()
+
+		if offset > 0 && !partial {
+			// The server ignored our Range request and sent the whole
+			// file back as 200 instead of 206; discard the partial
+			// bytes already on disk and treat this as a fresh download.
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			offset = 0
+		}
+
+		pg := newProgress(file, rfi.Size())
+		pg.done = offset
+		_, err = copyChunked(f, strm, *chunksize, pg)
 		if err == io.ErrUnexpectedEOF {
+			if fi, serr := os.Stat(file); serr == nil {
+				if *resume {
+					savePart(file, &davcpart{URL: p, Size: rfi.Size(), ModTime: rfi.ModTime(), Offset: fi.Size()})
+				}
+			}
 			return nil
 		}
-		return err
+		if err != nil {
+			if fi, serr := os.Stat(file); serr == nil && *resume {
+				savePart(file, &davcpart{URL: p, Size: rfi.Size(), ModTime: rfi.ModTime(), Offset: fi.Size()})
+			}
+			return err
+		}
+		pg.done_()
+		if *resume {
+			removePart(file)
+		}
 	case "cp":
 		if len(args) != 3 {
 			return invalidArg
@@ -350,7 +929,7 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 		if !path.IsAbs(dst) {
 			dst = path.Join(*cwd, dst)
 		}
-		err := client.Rename(src, dst, true)
+		err := sess.rawMove(src, dst, sess.lockHeaders(src))
 		if err != nil {
 			return err
 		}
@@ -367,7 +946,7 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 			return err
 		}
 		defer strm.Close()
-		_, err = io.Copy(os.Stdout, strm)
+		_, err = io.Copy(color.Output, strm)
 		if err == io.ErrUnexpectedEOF {
 			err = nil
 		}
@@ -387,13 +966,20 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 		}
 		return err
 	case "edit", "vim":
-		if len(args) != 2 {
+		opts, rest := parseArgs(args[1:])
+		if len(rest) != 1 {
 			return invalidArg
 		}
-		p := args[1]
+		p := rest[0]
 		if !path.IsAbs(p) {
 			p = path.Join(*cwd, p)
 		}
+		if opts["lock"] {
+			if _, err := sess.Lock(p, "exclusive", "0", "60"); err != nil {
+				return err
+			}
+			defer sess.Unlock(p)
+		}
 		strm, err := client.ReadStream(p)
 		if err != nil {
 			return err
@@ -436,52 +1022,188 @@ func handle(client *gowebdav.Client, cwd *string, args []string) error {
 		if nfi.ModTime().Equal(fi.ModTime()) {
 			return nil
 		}
-		err = client.WriteStream(p, f, 0644)
+		err = sess.rawPut(p, f, sess.lockHeaders(p))
 		if err != nil {
 			return err
 		}
-	case "exit":
-		os.Exit(0)
-	default:
-		return errors.New("unknown command")
-	}
-	return nil
-}
-
-var localCommands = []string{"lpwd", "lmkdir", "lrm", "lrmdir"}
-var remoteCommands = []string{"cd", "pwd", "mkdir", "rm", "rmdir", "cat", "edit", "vim", "get", "cp", "mv"}
-var allCommands = []string{}
-
-func init() {
-	allCommands = append(allCommands, localCommands...)
-	allCommands = append(allCommands, remoteCommands...)
-}
-
-func isLocalCompletion(cmd string, narg int) (bool, bool) {
-	if cmd == "put" {
-		if narg == 2 {
-			return false, false
-		} else {
-			return true, false
+	case "getprop":
+		opts, rest := parseArgs(args[1:])
+		if len(rest) < 1 {
+			return invalidArg
 		}
-	}
-	for _, n := range []string{"put"} {
-		if cmd == n {
-			return true, false
+		p := rest[0]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
 		}
-	}
-	for _, n := range []string{"cat", "edit"} {
-		if cmd == n {
-			return false, false
+		results, err := sess.Propfind(p, "0", rest[1:])
+		if err != nil {
+			return err
 		}
-	}
-	for _, n := range []string{"lcd", "lmkdir", "lrmdir"} {
-		if cmd == n {
-			return true, true
+		printPropResults(results, opts["json"])
+	case "setprop":
+		if len(args) < 3 {
+			return invalidArg
 		}
-	}
-	for _, n := range []string{"cd", "mkdir", "rmdir"} {
-		if cmd == n {
+		p := args[1]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
+		}
+		sets := map[string]string{}
+		for _, kv := range args[2:] {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 {
+				return invalidArg
+			}
+			sets[kv[:i]] = kv[i+1:]
+		}
+		if err := sess.Proppatch(p, sets, nil); err != nil {
+			return err
+		}
+	case "delprop":
+		if len(args) != 3 {
+			return invalidArg
+		}
+		p := args[1]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
+		}
+		if err := sess.Proppatch(p, nil, []string{args[2]}); err != nil {
+			return err
+		}
+	case "find":
+		var wheres []string
+		var jsonout bool
+		var rest []string
+		for i := 1; i < len(args); i++ {
+			switch args[i] {
+			case "--where":
+				i++
+				if i >= len(args) {
+					return invalidArg
+				}
+				wheres = append(wheres, args[i])
+			case "-json":
+				jsonout = true
+			default:
+				rest = append(rest, args[i])
+			}
+		}
+		if len(rest) != 1 {
+			return invalidArg
+		}
+		p := rest[0]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
+		}
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		results, err := sess.Propfind(p, "infinity", nil)
+		if err != nil {
+			return err
+		}
+		preds := make([]propPredicate, 0, len(wheres))
+		for _, w := range wheres {
+			pr, err := parsePredicate(w)
+			if err != nil {
+				return err
+			}
+			preds = append(preds, pr)
+		}
+		matched := []propResult{}
+		for _, r := range results {
+			if matchesAll(r, preds) {
+				matched = append(matched, r)
+			}
+		}
+		printPropResults(matched, jsonout)
+	case "lock":
+		opts, _, rest := parseKVArgs(args[1:])
+		if len(rest) != 1 {
+			return invalidArg
+		}
+		p := rest[0]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
+		}
+		scope := opts["scope"]
+		if scope == "" {
+			scope = "exclusive"
+		}
+		depth := opts["depth"]
+		token, err := sess.Lock(p, scope, depth, opts["timeout"])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(color.Output, color.GreenString("locked")+" "+p+" ("+token+")")
+	case "unlock":
+		if len(args) != 2 {
+			return invalidArg
+		}
+		p := args[1]
+		if !path.IsAbs(p) {
+			p = path.Join(*cwd, p)
+		}
+		if err := sess.Unlock(p); err != nil {
+			return err
+		}
+	case "locks":
+		if len(args) != 1 {
+			return invalidArg
+		}
+		sess.locksMu.Lock()
+		for p, lt := range sess.Locks {
+			fmt.Fprintln(color.Output, p+"\t"+lt.Scope+"\t"+lt.Token)
+		}
+		sess.locksMu.Unlock()
+	case "mget":
+		return doMget(client, cwd, lwd, args[1:])
+	case "mput":
+		return doMput(client, cwd, lwd, args[1:])
+	case "sync":
+		return doSync(client, cwd, lwd, args[1:])
+	case "exit":
+		os.Exit(0)
+	default:
+		return errors.New("unknown command")
+	}
+	return nil
+}
+
+var localCommands = []string{"lpwd", "lmkdir", "lrm", "lrmdir"}
+var remoteCommands = []string{"cd", "pwd", "mkdir", "rm", "rmdir", "cat", "edit", "vim", "get", "cp", "mv", "mget", "mput", "sync", "lock", "unlock", "locks", "getprop", "setprop", "delprop", "find"}
+var allCommands = []string{}
+
+func init() {
+	allCommands = append(allCommands, localCommands...)
+	allCommands = append(allCommands, remoteCommands...)
+}
+
+func isLocalCompletion(cmd string, narg int) (bool, bool) {
+	if cmd == "put" {
+		if narg == 2 {
+			return false, false
+		} else {
+			return true, false
+		}
+	}
+	for _, n := range []string{"put"} {
+		if cmd == n {
+			return true, false
+		}
+	}
+	for _, n := range []string{"cat", "edit"} {
+		if cmd == n {
+			return false, false
+		}
+	}
+	for _, n := range []string{"lcd", "lmkdir", "lrmdir"} {
+		if cmd == n {
+			return true, true
+		}
+	}
+	for _, n := range []string{"cd", "mkdir", "rmdir"} {
+		if cmd == n {
 			return false, true
 		}
 	}
@@ -493,7 +1215,8 @@ func isLocalCompletion(cmd string, narg int) (bool, bool) {
 	return false, false
 }
 
-func complete(client *gowebdav.Client, cwd *string, l string) (c []string) {
+func complete(sess *Session, cwd *string, l string) (c []string) {
+	client := sess.Client
 	args, err := shellwords.Parse(string(l))
 	if err != nil || len(args) == 0 {
 		return allCommands
@@ -506,6 +1229,20 @@ func complete(client *gowebdav.Client, cwd *string, l string) (c []string) {
 		}
 		return
 	}
+	if args[0] == "unlock" {
+		sess.locksMu.Lock()
+		defer sess.locksMu.Unlock()
+		prefix := ""
+		if len(args) > 1 && !strings.HasSuffix(l, " ") {
+			prefix = args[len(args)-1]
+		}
+		for p := range sess.Locks {
+			if strings.HasPrefix(p, prefix) {
+				c = append(c, l+escape(p[len(prefix):]))
+			}
+		}
+		return
+	}
 	ncomplete := len(args)
 	if len(args) > 1 && !strings.HasSuffix(l, " ") {
 		ncomplete++
@@ -584,6 +1321,675 @@ func complete(client *gowebdav.Client, cwd *string, l string) (c []string) {
 	return
 }
 
+// Session wraps a gowebdav.Client with a tuned http.Client, transparent
+// retry on transient network errors, and reconnect-plus-reauth if the
+// server drops the connection mid-REPL.
+type Session struct {
+	Client   *gowebdav.Client
+	URL      *url.URL
+	User     string
+	Password string
+	Line     *liner.State
+	OnRetry  func(attempt int, err error)
+
+	maxRetries int
+
+	hc      *http.Client
+	locksMu sync.Mutex
+	Locks   map[string]lockToken
+}
+
+// lockToken is a held WebDAV lock, keyed by the locked path in the
+// session's lock table.
+type lockToken struct {
+	Token string
+	Scope string
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+func newClient(u *url.URL, user, password string) *gowebdav.Client {
+	c := gowebdav.NewClient(u.Scheme+"://"+u.Host, user, password)
+	c.SetTransport(newTransport())
+	return c
+}
+
+// NewSession builds a Session against u, re-prompting for credentials
+// via line when needed.
+func NewSession(u *url.URL, user, password string, line *liner.State) *Session {
+	return &Session{
+		URL:        u,
+		User:       user,
+		Password:   password,
+		Line:       line,
+		Client:     newClient(u, user, password),
+		maxRetries: 3,
+	}
+}
+
+func (s *Session) connect() error {
+	return s.Client.Connect()
+}
+
+// reconnect rebuilds the underlying client against the same host,
+// re-prompting for credentials first when authErr indicates the
+// previous ones were rejected.
+func (s *Session) reconnect(authErr error) error {
+	if authErr != nil && s.Line != nil {
+		user, err := s.Line.Prompt("User: ")
+		if err != nil {
+			return err
+		}
+		password, err := s.Line.PasswordPrompt("Password: ")
+		if err != nil {
+			return err
+		}
+		s.User, s.Password = user, password
+	}
+	s.Client = newClient(s.URL, s.User, s.Password)
+	return s.Client.Connect()
+}
+
+// request issues a raw WebDAV request, reusing the session's host and
+// credentials: for methods gowebdav.Client does not expose (LOCK, UNLOCK,
+// PROPFIND, PROPPATCH), and for the PUT, GET, DELETE and MOVE that need
+// per-request headers (see rawPut).
+func (s *Session) request(method, p string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	if s.hc == nil {
+		s.hc = &http.Client{Transport: newTransport()}
+	}
+	full := s.URL.Scheme + "://" + s.URL.Host + p
+	req, err := http.NewRequest(method, full, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.User != "" {
+		req.SetBasicAuth(s.User, s.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return s.hc.Do(req)
+}
+
+// statusError is returned by the raw WebDAV request helpers when the
+// server responds with a non-success status. It carries the numeric
+// code alongside the formatted message so callers like isAuthError
+// don't have to parse it back out of resp.Status.
+type statusError struct {
+	method string
+	path   string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.path, e.status)
+}
+
+func newStatusError(method, p string, resp *http.Response) error {
+	return &statusError{method: method, path: p, status: resp.Status, code: resp.StatusCode}
+}
+
+// rawPut issues a PUT against p with the given extra headers (e.g.
+// Content-Range, If). Unlike gowebdav.Client.WriteStream it goes
+// through Session.request directly, so a header carried on one put
+// never lingers to be resent on later, unrelated requests the way
+// repeated Client.SetHeader(key, "") calls on the shared client would.
+func (s *Session) rawPut(p string, body io.Reader, headers map[string]string) error {
+	resp, err := s.request("PUT", p, body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newStatusError("PUT", p, resp)
+	}
+	return nil
+}
+
+// rawGet issues a GET against p with the given extra headers (e.g.
+// Range) and returns the response body for the caller to copy and
+// close, for the same reason rawPut bypasses the shared client.
+// rawGet also reports whether the response was 206 Partial Content: a
+// Range request that the server doesn't honor comes back 200 with the
+// full body instead, and the caller must not append that onto bytes
+// it already has on disk.
+func (s *Session) rawGet(p string, headers map[string]string) (body io.ReadCloser, partial bool, err error) {
+	resp, err := s.request("GET", p, nil, headers)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, false, newStatusError("GET", p, resp)
+	}
+	return resp.Body, resp.StatusCode == http.StatusPartialContent, nil
+}
+
+// rawDelete issues a DELETE against p with the given extra headers
+// (e.g. If for a locked path).
+func (s *Session) rawDelete(p string, headers map[string]string) error {
+	resp, err := s.request("DELETE", p, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newStatusError("DELETE", p, resp)
+	}
+	return nil
+}
+
+// rawMove issues a MOVE against src with Destination set to dst and
+// the given extra headers (e.g. If for a locked path).
+func (s *Session) rawMove(src, dst string, headers map[string]string) error {
+	h := map[string]string{
+		"Destination": s.URL.Scheme + "://" + s.URL.Host + dst,
+		"Overwrite":   "T",
+		"Depth":       "infinity",
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+	resp, err := s.request("MOVE", src, nil, h)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newStatusError("MOVE", src, resp)
+	}
+	return nil
+}
+
+// lockHeaders returns the headers needed to carry this session's lock
+// token on a write to p, if it holds one, for use with rawPut/rawDelete/
+// rawMove, so the If: header is sent only on that one request and never
+// set on the shared gowebdav.Client.
+func (s *Session) lockHeaders(p string) map[string]string {
+	if ifh := s.ifHeader(p); ifh != "" {
+		return map[string]string{"If": ifh}
+	}
+	return nil
+}
+
+// Lock issues a LOCK request against p and records the returned token
+// in the session's lock table.
+func (s *Session) Lock(p, scope, depth, timeout string) (string, error) {
+	if scope == "" {
+		scope = "exclusive"
+	}
+	scopeElem := "<D:exclusive/>"
+	if scope == "shared" {
+		scopeElem = "<D:shared/>"
+	}
+	body := `<?xml version="1.0" encoding="utf-8" ?>` + "\n" +
+		`<D:lockinfo xmlns:D="DAV:">` + "\n" +
+		"  <D:lockscope>" + scopeElem + "</D:lockscope>\n" +
+		"  <D:locktype><D:write/></D:locktype>\n" +
+		"</D:lockinfo>"
+	headers := map[string]string{"Content-Type": `text/xml; charset="utf-8"`}
+	if depth == "" {
+		depth = "0"
+	}
+	headers["Depth"] = depth
+	if timeout != "" {
+		headers["Timeout"] = "Second-" + timeout
+	}
+	resp, err := s.request("LOCK", p, strings.NewReader(body), headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", newStatusError("LOCK", p, resp)
+	}
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	s.locksMu.Lock()
+	if s.Locks == nil {
+		s.Locks = map[string]lockToken{}
+	}
+	s.Locks[p] = lockToken{Token: token, Scope: scope}
+	s.locksMu.Unlock()
+	return token, nil
+}
+
+// Unlock releases a lock previously acquired by this session.
+func (s *Session) Unlock(p string) error {
+	s.locksMu.Lock()
+	lt, ok := s.Locks[p]
+	s.locksMu.Unlock()
+	if !ok {
+		return fmt.Errorf("not locked: %s", p)
+	}
+	resp, err := s.request("UNLOCK", p, nil, map[string]string{"Lock-Token": "<" + lt.Token + ">"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return newStatusError("UNLOCK", p, resp)
+	}
+	s.locksMu.Lock()
+	delete(s.Locks, p)
+	s.locksMu.Unlock()
+	return nil
+}
+
+// ifHeader returns the If: header value to submit with put/rm/mv/edit
+// when this session already holds a lock on p, so two davc users
+// sharing a server don't clobber each other.
+func (s *Session) ifHeader(p string) string {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	if lt, ok := s.Locks[p]; ok {
+		return "(<" + lt.Token + ">)"
+	}
+	return ""
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"href"`
+	Propstat []davPropstat `xml:"propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"prop"`
+	Status string  `xml:"status"`
+}
+
+type davProp struct {
+	XML []davXMLProp `xml:",any"`
+}
+
+type davXMLProp struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// propResult is the property set found on one resource, keyed the same
+// way getprop/setprop/find accept them: "DAV:name" or "ns:name".
+type propResult struct {
+	Href  string            `json:"href"`
+	Props map[string]string `json:"props"`
+}
+
+func splitProp(s string) (ns, name string) {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return "DAV", s
+}
+
+func propNamespace(ns string) string {
+	if ns == "DAV" {
+		return "DAV:"
+	}
+	return "urn:davc:" + ns
+}
+
+func propKey(name xml.Name) string {
+	if name.Space == "" || name.Space == "DAV:" {
+		return "DAV:" + name.Local
+	}
+	return strings.TrimPrefix(name.Space, "urn:davc:") + ":" + name.Local
+}
+
+func buildPropfindBody(props []string) string {
+	if len(props) == 0 {
+		return `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8" ?>` + "\n" + `<D:propfind xmlns:D="DAV:">` + "\n  <D:prop>\n")
+	for i, p := range props {
+		ns, name := splitProp(p)
+		fmt.Fprintf(&b, "    <ns%d:%s xmlns:ns%d=%q/>\n", i, name, i, propNamespace(ns))
+	}
+	b.WriteString("  </D:prop>\n</D:propfind>")
+	return b.String()
+}
+
+// Propfind issues a PROPFIND against p and returns every matched
+// resource's properties. An empty props list requests D:allprop.
+func (s *Session) Propfind(p, depth string, props []string) ([]propResult, error) {
+	resp, err := s.request("PROPFIND", p, strings.NewReader(buildPropfindBody(props)), map[string]string{
+		"Content-Type": `text/xml; charset="utf-8"`,
+		"Depth":        depth,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 207 {
+		return nil, newStatusError("PROPFIND", p, resp)
+	}
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	results := make([]propResult, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		pr := propResult{Href: r.Href, Props: map[string]string{}}
+		for _, ps := range r.Propstat {
+			if !strings.HasPrefix(ps.Status, "HTTP/1.1 200") {
+				continue
+			}
+			for _, x := range ps.Prop.XML {
+				pr.Props[propKey(x.XMLName)] = strings.TrimSpace(x.Value)
+			}
+		}
+		results = append(results, pr)
+	}
+	return results, nil
+}
+
+// Proppatch issues a PROPPATCH against p, setting sets and removing
+// dels in a single request.
+func (s *Session) Proppatch(p string, sets map[string]string, dels []string) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8" ?>` + "\n" + `<D:propertyupdate xmlns:D="DAV:">` + "\n")
+	if len(sets) > 0 {
+		b.WriteString("  <D:set>\n    <D:prop>\n")
+		i := 0
+		for k, v := range sets {
+			ns, name := splitProp(k)
+			var esc strings.Builder
+			xml.EscapeText(&esc, []byte(v))
+			fmt.Fprintf(&b, "      <ns%d:%s xmlns:ns%d=%q>%s</ns%d:%s>\n", i, name, i, propNamespace(ns), esc.String(), i, name)
+			i++
+		}
+		b.WriteString("    </D:prop>\n  </D:set>\n")
+	}
+	if len(dels) > 0 {
+		b.WriteString("  <D:remove>\n    <D:prop>\n")
+		for i, k := range dels {
+			ns, name := splitProp(k)
+			fmt.Fprintf(&b, "      <ns%d:%s xmlns:ns%d=%q/>\n", i, name, i, propNamespace(ns))
+		}
+		b.WriteString("    </D:prop>\n  </D:remove>\n")
+	}
+	b.WriteString("</D:propertyupdate>")
+	resp, err := s.request("PROPPATCH", p, strings.NewReader(b.String()), map[string]string{"Content-Type": `text/xml; charset="utf-8"`})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 207 && resp.StatusCode != http.StatusOK {
+		return newStatusError("PROPPATCH", p, resp)
+	}
+	return nil
+}
+
+func printPropResults(results []propResult, jsonout bool) {
+	if jsonout {
+		json.NewEncoder(color.Output).Encode(results)
+		return
+	}
+	for _, r := range results {
+		fmt.Fprintln(color.Output, color.GreenString(r.Href))
+		for k, v := range r.Props {
+			fmt.Fprintln(color.Output, "  "+k+" = "+v)
+		}
+	}
+}
+
+// propPredicate is one --where clause of find, e.g. "DAV:getcontentlength>1000000".
+type propPredicate struct {
+	Key string
+	Op  string
+	Val string
+}
+
+func parsePredicate(s string) (propPredicate, error) {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if i := strings.Index(s, op); i >= 0 {
+			return propPredicate{Key: s[:i], Op: op, Val: s[i+len(op):]}, nil
+		}
+	}
+	return propPredicate{}, invalidArg
+}
+
+func matchPredicate(v, op, want string) bool {
+	vn, verr := strconv.ParseFloat(v, 64)
+	wn, werr := strconv.ParseFloat(want, 64)
+	numeric := verr == nil && werr == nil
+	switch op {
+	case "=":
+		return v == want
+	case "!=":
+		return v != want
+	case ">":
+		return numeric && vn > wn
+	case "<":
+		return numeric && vn < wn
+	case ">=":
+		return numeric && vn >= wn
+	case "<=":
+		return numeric && vn <= wn
+	}
+	return false
+}
+
+func matchesAll(r propResult, preds []propPredicate) bool {
+	for _, pr := range preds {
+		v, ok := r.Props[pr.Key]
+		if !ok || !matchPredicate(v, pr.Op, pr.Val) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{"connection reset", "broken pipe", "EOF", "500", "502", "503", "504"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuthError reports whether err is the server rejecting the
+// session's credentials: a *statusError with code 401 from the raw
+// request helpers, or, from the handful of commands still going
+// through gowebdav.Client directly, its bare "401" status string
+// optionally wrapped in os.PathError.
+func isAuthError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusUnauthorized
+	}
+	if ep, ok := err.(*os.PathError); ok {
+		err = ep.Err
+	}
+	return err != nil && err.Error() == "401"
+}
+
+// Run executes fn, transparently retrying with exponential backoff and
+// reconnecting the session's client on transient network errors. On an
+// auth error it also re-prompts for credentials, so a session dropped
+// mid-REPL comes back instead of failing every command after it.
+func (s *Session) Run(fn func() error) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		authErr := isAuthError(err)
+		if !authErr && !isTransient(err) {
+			return err
+		}
+		if attempt == s.maxRetries {
+			break
+		}
+		if s.OnRetry != nil {
+			s.OnRetry(attempt+1, err)
+		}
+		var reauth error
+		if authErr {
+			reauth = err
+		}
+		if rerr := s.reconnect(reauth); rerr != nil {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return err
+}
+
+// exitVarPattern matches a standalone $? token, so substitution doesn't
+// corrupt occurrences of "$?" embedded in a larger quoted argument.
+var exitVarPattern = regexp.MustCompile(`(^|\s)\$\?(\s|$)`)
+
+// splitPipe splits a script line on the first top-level |, the way a
+// shell would: a | inside quotes does not introduce a pipeline. It
+// scans the raw characters rather than round-tripping through
+// shellwords.Parse/strings.Join, which would discard quoting and
+// corrupt quoted multi-word arguments on the piped side.
+func splitPipe(line string) (davPart, shPart string, piped bool) {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '\\':
+			escaped = true
+		case r == '|':
+			return line[:i], line[i+1:], true
+		}
+	}
+	return line, "", false
+}
+
+// runLine executes a single script line, expanding $? to the previous
+// line's exit status (0 ok, 1 error) and, when the line contains a
+// top-level |, piping the davc command's output into a local shell
+// command via exec.Command.
+func runLine(sess *Session, cwd *string, line string, lastStatus int) (int, error) {
+	// Repeat until nothing changes: a match consumes the whitespace after
+	// it, so the second of two adjacent "$? $?" tokens needs another pass.
+	for {
+		expanded := exitVarPattern.ReplaceAllString(line, "${1}"+strconv.Itoa(lastStatus)+"${2}")
+		if expanded == line {
+			break
+		}
+		line = expanded
+	}
+	davPart, shPart, piped := splitPipe(line)
+
+	davArgs, err := shellwords.Parse(strings.TrimSpace(davPart))
+	if err != nil {
+		return 1, err
+	}
+	if len(davArgs) == 0 {
+		return lastStatus, nil
+	}
+
+	if !piped {
+		if err := sess.Run(func() error { return handle(sess, cwd, davArgs) }); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	shArgs, err := shellwords.Parse(strings.TrimSpace(shPart))
+	if err != nil || len(shArgs) == 0 {
+		return 1, invalidArg
+	}
+
+	var buf syncBuffer
+	saved := color.Output
+	color.Output = &buf
+	err = sess.Run(func() error { return handle(sess, cwd, davArgs) })
+	color.Output = saved
+	if err != nil {
+		return 1, err
+	}
+
+	cmd := exec.Command(shArgs[0], shArgs[1:]...)
+	cmd.Stdin = &buf.buf
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// syncBuffer is a bytes.Buffer whose writes are guarded by a mutex, used
+// as color.Output while it is temporarily redirected into it for piping.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// runScript reads davc commands one per line from r, the non-interactive
+// counterpart to the liner REPL, honoring --on-error.
+func runScript(sess *Session, cwd *string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lastStatus := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var err error
+		lastStatus, err = runLine(sess, cwd, line, lastStatus)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if *onError != "continue" {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
 func main() {
 	flag.Parse()
 	if flag.NArg() == 0 {
@@ -622,8 +2028,8 @@ func main() {
 		u.Scheme = "https"
 	}
 
-	client := gowebdav.NewClient(u.Scheme+"://"+u.Host, user, password)
-	err = client.Connect()
+	sess := NewSession(u, user, password, line)
+	err = sess.connect()
 	if err != nil {
 		ep, ok := err.(*os.PathError)
 		if ok {
@@ -635,34 +2041,45 @@ func main() {
 			if *cred != "" {
 				fatal(err)
 			}
-			user, err = line.Prompt("User: ")
-			if err != nil {
-				fatalRequiredAuth(err)
-			}
-			password, err = line.PasswordPrompt("Password: ")
-			if err != nil {
+			if err = sess.reconnect(err); err != nil {
 				fatalRequiredAuth(err)
 			}
-			client = gowebdav.NewClient(u.Scheme+"://"+u.Host, user, password)
-			err = client.Connect()
-			if err != nil {
-				fatal(err)
-			}
 		default:
 			fatal(err)
 		}
 	}
+	sess.OnRetry = func(attempt int, rerr error) {
+		fmt.Fprintln(color.Output, color.YellowString("retry %d/%d after %v", attempt, sess.maxRetries, rerr))
+	}
 
 	cwd := u.Path
 	if !strings.HasSuffix(cwd, "/") {
 		cwd += "/"
 	}
 	if flag.NArg() == 1 {
+		if *scriptFile != "" {
+			f, err := os.Open(*scriptFile)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			if err := runScript(sess, &cwd, f); err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+		if fi, ferr := os.Stdin.Stat(); ferr == nil && fi.Mode()&os.ModeCharDevice == 0 {
+			if err := runScript(sess, &cwd, os.Stdin); err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+
 		line := liner.NewLiner()
 		defer line.Close()
 
 		line.SetCompleter(func(l string) (c []string) {
-			return complete(client, &cwd, l)
+			return complete(sess, &cwd, l)
 		})
 
 		for {
@@ -683,14 +2100,18 @@ func main() {
 				continue
 			}
 			line.AppendHistory(l)
-			err = handle(client, &cwd, args)
+			err = sess.Run(func() error {
+				return handle(sess, &cwd, args)
+			})
 			if err != nil {
 				fmt.Fprintln(color.Output, color.RedString("%v", err.Error()))
 				continue
 			}
 		}
 	} else {
-		err = handle(client, &cwd, flag.Args()[1:])
+		err = sess.Run(func() error {
+			return handle(sess, &cwd, flag.Args()[1:])
+		})
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 		}